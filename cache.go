@@ -0,0 +1,144 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	fCacheDir = flag.String("cache", defaultCacheDir(), "directory to cache downloaded tarballs/zips in, keyed by <import-path>@<revision>")
+	fNoCache  = flag.Bool("no-cache", false, "disable the download cache")
+)
+
+// defaultCacheDir follows the XDG Base Directory spec: $XDG_CACHE_HOME/dep-dl,
+// falling back to $HOME/.cache/dep-dl.
+func defaultCacheDir() string {
+	if xdg := os.Getenv("XDG_CACHE_HOME"); xdg != "" {
+		return filepath.Join(xdg, "dep-dl")
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".cache", "dep-dl")
+	}
+	return filepath.Join(os.TempDir(), "dep-dl-cache")
+}
+
+// cachePath returns the on-disk path for the cached archive of
+// importPath@revision, or "" if caching is disabled.
+func cachePath(importPath, revision, ext string) string {
+	if *fNoCache {
+		return ""
+	}
+	return filepath.Join(*fCacheDir, escapeModulePath(importPath)+"@"+escapeModulePath(revision)+ext)
+}
+
+// cacheLookup reports whether importPath@revision is already cached,
+// returning its path if so.
+func cacheLookup(importPath, revision, ext string) (string, bool) {
+	path := cachePath(importPath, revision, ext)
+	if path == "" {
+		return "", false
+	}
+	if _, err := os.Stat(path); err != nil {
+		return "", false
+	}
+	return path, true
+}
+
+// cacheStore copies src into the cache slot for importPath@revision,
+// returning the final path to read from (src unchanged if caching is
+// disabled).
+func cacheStore(importPath, revision, ext, src string) (string, error) {
+	path := cachePath(importPath, revision, ext)
+	if path == "" {
+		return src, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return "", errors.WithStack(err)
+	}
+	if err := copyFile(src, path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, in); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// cacheLookupDir reports whether importPath@revision already has a cached
+// VCS checkout, returning its directory if so.
+func cacheLookupDir(importPath, revision string) (string, bool) {
+	path := cachePath(importPath, revision, ".vcs")
+	if path == "" {
+		return "", false
+	}
+	info, err := os.Stat(path)
+	if err != nil || !info.IsDir() {
+		return "", false
+	}
+	return path, true
+}
+
+// cacheStoreDir copies the checked-out directory at src into the cache
+// slot for importPath@revision, returning the final path to read from
+// (src unchanged if caching is disabled).
+func cacheStoreDir(importPath, revision, src string) (string, error) {
+	path := cachePath(importPath, revision, ".vcs")
+	if path == "" {
+		return src, nil
+	}
+	os.RemoveAll(path)
+	if err := copyDir(src, path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// copyDir recursively copies the directory tree at src into dst,
+// preserving symlinks as symlinks.
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		target := filepath.Join(dst, rel)
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			link, err := os.Readlink(p)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			return os.Symlink(link, target)
+		}
+		if info.IsDir() {
+			return errors.WithStack(os.MkdirAll(target, info.Mode()))
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0777); err != nil {
+			return errors.WithStack(err)
+		}
+		return copyFile(p, target)
+	})
+}
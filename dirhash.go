@@ -0,0 +1,67 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// hashZip computes the "h1:" dirhash of a module zip as downloaded from a
+// module proxy, using the same algorithm as golang.org/x/mod/sumdb/dirhash
+// (files sorted by name, sha256 of each file written as a "<hex>  <name>"
+// line in that order, then sha256 of the concatenation) so the result can
+// be checked against a go.sum entry.
+func hashZip(path string) (string, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer zr.Close()
+
+	files := make([]*zip.File, 0, len(zr.File))
+	for _, f := range zr.File {
+		if strings.HasSuffix(f.Name, "/") {
+			continue
+		}
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+	h := sha256.New()
+	for _, f := range files {
+		if err := func() error {
+			rc, err := f.Open()
+			if err != nil {
+				return err
+			}
+			defer rc.Close()
+
+			fh := sha256.New()
+			if _, err := io.Copy(fh, rc); err != nil {
+				return err
+			}
+			fmt.Fprintf(h, "%x  %s\n", fh.Sum(nil), f.Name)
+			return nil
+		}(); err != nil {
+			return "", errors.WithStack(err)
+		}
+	}
+	return "h1:" + base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashGoMod computes the "h1:" dirhash of a go.mod file the way
+// golang.org/x/mod/sumdb/dirhash.Hash1 does when given a single file: the
+// sha256 of data is written as a "<hex>  <name>" line, where name is
+// "<path>@<version>/go.mod", and the result is the sha256 of that line.
+func hashGoMod(path, version string, data []byte) string {
+	fileHash := sha256.Sum256(data)
+	h := sha256.New()
+	fmt.Fprintf(h, "%x  %s@%s/go.mod\n", fileHash, path, version)
+	return "h1:" + base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// safeJoin joins name onto baseDir the way an archive extractor must:
+// rejecting any entry whose cleaned path would escape baseDir (the
+// "zip slip" / tar path traversal family of vulnerabilities).
+func safeJoin(baseDir, name string) (string, error) {
+	target := filepath.Join(baseDir, name)
+	if target != baseDir && !strings.HasPrefix(target, baseDir+string(filepath.Separator)) {
+		return "", errors.Errorf("refusing to extract %q: escapes %s", name, baseDir)
+	}
+	return target, nil
+}
+
+// safeSymlink validates that a symlink's resolved target stays within
+// baseDir before creating it, then creates it with the arguments in the
+// order os.Symlink actually expects: (oldname, newname), i.e. (the link
+// text, the path of the link file itself) — the original code had these
+// reversed.
+func safeSymlink(baseDir, target, linkname string) error {
+	resolved := linkname
+	if !filepath.IsAbs(resolved) {
+		resolved = filepath.Join(filepath.Dir(target), linkname)
+	}
+	resolved = filepath.Clean(resolved)
+	if resolved != baseDir && !strings.HasPrefix(resolved, baseDir+string(filepath.Separator)) {
+		return errors.Errorf("refusing to create symlink %q -> %q: escapes %s", target, linkname, baseDir)
+	}
+	return os.Symlink(linkname, target)
+}
+
+// safeExtractTar extracts a tar stream into baseDir, rejecting entries
+// (regular files, directories, symlinks, hardlinks) whose name or, for
+// symlinks, resolved target would escape baseDir. rename is called with
+// each entry's header name and returns the on-disk path to write it to
+// relative to baseDir, or ok=false to skip the entry entirely (used to
+// strip an archive-specific root prefix and filter to selected
+// subdirectories).
+func safeExtractTar(r io.Reader, baseDir string, rename func(name string) (string, bool)) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		rel, ok := rename(hdr.Name)
+		if !ok {
+			continue
+		}
+		target, err := safeJoin(baseDir, rel)
+		if err != nil {
+			return err
+		}
+		if target == baseDir {
+			continue
+		}
+
+		if *fVerbose {
+			fmt.Println("Writing:", target)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, os.FileMode(hdr.Mode)); err != nil && !os.IsExist(err) {
+				return errors.WithStack(err)
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if err := extractTarFile(tr, target, os.FileMode(hdr.Mode)); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := safeSymlink(baseDir, target, hdr.Linkname); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			// hdr.Linkname names another entry in the same archive, in the
+			// same pre-rename namespace as hdr.Name, so it needs the same
+			// rename (root-prefix stripping, subdir filtering) applied.
+			oldRel, ok := rename(hdr.Linkname)
+			if !ok {
+				continue
+			}
+			oldTarget, err := safeJoin(baseDir, oldRel)
+			if err != nil {
+				return err
+			}
+			if err := os.Link(oldTarget, target); err != nil {
+				return errors.WithStack(err)
+			}
+		default:
+			continue
+		}
+
+		os.Chtimes(target, hdr.AccessTime, hdr.ModTime)
+	}
+}
+
+func extractTarFile(r io.Reader, target string, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0777); err != nil && !os.IsExist(err) {
+		return errors.WithStack(err)
+	}
+	f, err := os.OpenFile(target, os.O_RDWR|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, r); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// safeExtractZip extracts a zip archive into baseDir, applying the same
+// path-traversal protection as safeExtractTar. rename has the same
+// contract as in safeExtractTar.
+func safeExtractZip(zr *zip.Reader, baseDir string, rename func(name string) (string, bool)) error {
+	for _, f := range zr.File {
+		rel, ok := rename(f.Name)
+		if !ok {
+			continue
+		}
+		target, err := safeJoin(baseDir, rel)
+		if err != nil {
+			return err
+		}
+		if target == baseDir {
+			continue
+		}
+
+		if strings.HasSuffix(f.Name, "/") {
+			if err := os.MkdirAll(target, 0777); err != nil && !os.IsExist(err) {
+				return errors.WithStack(err)
+			}
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(target), 0777); err != nil && !os.IsExist(err) {
+			return errors.WithStack(err)
+		}
+		if err := extractZipFile(f, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractZipFile(f *zip.File, target string) error {
+	rc, err := f.Open()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer rc.Close()
+
+	out, err := os.OpenFile(target, os.O_RDWR|os.O_CREATE|os.O_TRUNC, f.Mode())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, rc); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
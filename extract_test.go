@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSafeJoin(t *testing.T) {
+	const baseDir = "/base"
+
+	tests := []struct {
+		name     string
+		entry    string
+		wantPath string
+		wantErr  bool
+	}{
+		{name: "plain file", entry: "foo.txt", wantPath: "/base/foo.txt"},
+		{name: "nested dirs", entry: "a/b/c.txt", wantPath: "/base/a/b/c.txt"},
+		{name: "dot entry stays at baseDir", entry: ".", wantPath: "/base"},
+		{name: "dot-dot escape", entry: "../../etc/passwd", wantErr: true},
+		{name: "dot-dot buried in a longer path", entry: "a/../../b", wantErr: true},
+		{name: "sibling directory sharing baseDir's prefix", entry: "../based-evil/x", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := safeJoin(baseDir, tt.entry)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("safeJoin(%q, %q) = %q, want error", baseDir, tt.entry, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("safeJoin(%q, %q) returned unexpected error: %v", baseDir, tt.entry, err)
+			}
+			if got != tt.wantPath {
+				t.Fatalf("safeJoin(%q, %q) = %q, want %q", baseDir, tt.entry, got, tt.wantPath)
+			}
+		})
+	}
+}
+
+func TestSafeSymlink(t *testing.T) {
+	tests := []struct {
+		name     string
+		linkname string
+		wantErr  bool
+	}{
+		{name: "relative target inside baseDir", linkname: "file.txt"},
+		{name: "relative target escaping via dot-dot", linkname: "../../etc/passwd", wantErr: true},
+		{name: "absolute target outside baseDir", linkname: "/etc/passwd", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			baseDir := t.TempDir()
+			linkPath := filepath.Join(baseDir, "link")
+
+			err := safeSymlink(baseDir, linkPath, tt.linkname)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("safeSymlink(%q, %q, %q) = nil, want error", baseDir, linkPath, tt.linkname)
+				}
+				if _, statErr := os.Lstat(linkPath); statErr == nil {
+					t.Fatalf("safeSymlink(%q, %q, %q) returned an error but still created %q", baseDir, linkPath, tt.linkname, linkPath)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("safeSymlink(%q, %q, %q) returned unexpected error: %v", baseDir, linkPath, tt.linkname, err)
+			}
+			got, err := os.Readlink(linkPath)
+			if err != nil {
+				t.Fatalf("Readlink(%q): %v", linkPath, err)
+			}
+			if got != tt.linkname {
+				t.Fatalf("Readlink(%q) = %q, want %q", linkPath, got, tt.linkname)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var fGithubToken = flag.String("github-token", "", "GitHub API token sent as 'Authorization: token ...', defaults to $GITHUB_TOKEN")
+
+const (
+	githubMaxAttempts = 3
+	githubBackoffBase = 500 * time.Millisecond
+	githubBackoffCap  = 30 * time.Second
+	httpTimeout       = 30 * time.Second
+)
+
+// httpClient is shared by dlGithub and getGoImports so CI runs don't hang
+// indefinitely on a stalled connection.
+var httpClient = &http.Client{Timeout: httpTimeout}
+
+// githubToken returns the token to authenticate GitHub API requests with,
+// preferring -github-token over $GITHUB_TOKEN.
+func githubToken() string {
+	if *fGithubToken != "" {
+		return *fGithubToken
+	}
+	return os.Getenv("GITHUB_TOKEN")
+}
+
+// doGithubRequest performs req against api.github.com, attaching
+// authentication when a token is configured and retrying with exponential
+// backoff + jitter on network errors and 5xx responses. On rate-limit
+// exhaustion (X-RateLimit-Remaining: 0), it sleeps until X-RateLimit-Reset
+// instead of burning an attempt.
+func doGithubRequest(ctx context.Context, req *http.Request) (*http.Response, error) {
+	if token := githubToken(); token != "" {
+		req.Header.Set("Authorization", "token "+token)
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < githubMaxAttempts; attempt++ {
+		if attempt > 0 {
+			if err := sleepContext(ctx, githubBackoff(attempt)); err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			lastErr = errors.WithStack(err)
+			continue
+		}
+
+		if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+			reset := githubRateLimitReset(resp.Header.Get("X-RateLimit-Reset"))
+			resp.Body.Close()
+			if *fVerbose {
+				fmt.Fprintf(os.Stderr, "GitHub API rate limit exhausted, sleeping until %s\n", reset)
+			}
+			if err := sleepContext(ctx, time.Until(reset)); err != nil {
+				return nil, err
+			}
+			lastErr = errors.Errorf("GitHub API rate limit exhausted: %s", req.URL)
+			attempt--
+			continue
+		}
+
+		if resp.StatusCode >= 500 {
+			lastErr = errors.Errorf("GitHub API returned %s (URL: %s)", resp.Status, req.URL)
+			resp.Body.Close()
+			continue
+		}
+
+		return resp, nil
+	}
+	return nil, lastErr
+}
+
+// githubRateLimitReset parses an X-RateLimit-Reset header (seconds since
+// the Unix epoch), falling back to githubBackoffCap from now if it's
+// missing or malformed.
+func githubRateLimitReset(v string) time.Time {
+	sec, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Now().Add(githubBackoffCap)
+	}
+	return time.Unix(sec, 0)
+}
+
+// githubBackoff returns the delay before retry attempt n (1-based),
+// exponential in n and capped, with up to 50% jitter.
+func githubBackoff(attempt int) time.Duration {
+	d := githubBackoffBase << uint(attempt-1)
+	if d <= 0 || d > githubBackoffCap {
+		d = githubBackoffCap
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d)/2+1))
+}
+
+// sleepContext waits for d, returning early with ctx's error if ctx is
+// canceled first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
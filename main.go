@@ -1,9 +1,8 @@
 package main
 
 import (
-	"archive/tar"
-	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/xml"
 	"flag"
 	"fmt"
@@ -11,11 +10,11 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
 	"runtime/pprof"
 	"strings"
+	"sync/atomic"
 
 	toml "github.com/pelletier/go-toml"
 	"github.com/pkg/errors"
@@ -44,98 +43,108 @@ var (
 	fVerbose     = flag.Bool("v", false, "verbose output")
 	fParallelism = flag.Int("p", 4, "parallelism of download")
 	fCpuprofile  = flag.String("cpuprofile", "", "write cpu profile to file")
+	fKeepGoing   = flag.Bool("keep-going", false, "keep dispatching downloads after a failure instead of stopping early")
+	fFailFast    = flag.Bool("fail-fast", false, "on the first failure, cancel all in-flight downloads instead of waiting for them to finish")
 )
 
-func (pj *project) dlGithub(user, repo string) error {
-	tarballUrl := fmt.Sprintf("https://api.github.com/repos/%s/%s/tarball/%s", user, repo, pj.Revision)
-	resp, err := http.Get(tarballUrl)
-	if err != nil {
-		return errors.WithStack(err)
-	}
-	defer resp.Body.Close()
+// projectResult is one project's or module's outcome, collected from main's
+// worker goroutines instead of aborting the whole run on the first panic.
+type projectResult struct {
+	Name string
+	Err  error
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return errors.Errorf("failed getting tarball: status %s (URL: %s)", resp.Status, tarballUrl)
+func (pj *project) dlGithub(ctx context.Context, user, repo string) error {
+	tarPath, cached := cacheLookup(pj.Name, pj.Revision, ".tar.gz")
+	if !cached {
+		tmp, err := fetchGithubTarball(ctx, user, repo, pj.Revision)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp)
+
+		if tarPath, err = cacheStore(pj.Name, pj.Revision, ".tar.gz", tmp); err != nil {
+			return err
+		}
+	} else if *fVerbose {
+		fmt.Println("Using cached tarball:", pj.Name, pj.Revision)
 	}
 
-	body, err := ioutil.ReadAll(resp.Body)
+	f, err := os.Open(tarPath)
 	if err != nil {
-		return err
+		return errors.WithStack(err)
 	}
-	buf := bytes.NewBuffer(body)
+	defer f.Close()
 
-	gz, err := gzip.NewReader(buf)
+	gz, err := gzip.NewReader(f)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 	defer gz.Close()
 
-	files := tar.NewReader(gz)
-
 	baseDir := filepath.Join(vendorDir, pj.Name)
 	if err := os.RemoveAll(baseDir); err != nil && !os.IsNotExist(err) {
 		return errors.WithStack(err)
 	}
-	if err = os.MkdirAll(baseDir, 0777); err != nil {
+	if err := os.MkdirAll(baseDir, 0777); err != nil {
 		return errors.WithStack(err)
 	}
-	for {
-		hdr, err := files.Next()
 
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return errors.WithStack(err)
-		}
-
-		nameDirs := strings.Split(hdr.Name, "/")
-		if len(nameDirs) > 2 {
-			pkg := filepath.Join(nameDirs[1 : len(nameDirs)-1]...)
-			if !pj.subdirTable[pkg] {
-				continue
-			}
-		}
+	return safeExtractTar(gz, baseDir, pj.renameGithubEntry)
+}
 
-		target := filepath.Join(baseDir, filepath.Join(nameDirs[1:]...))
-		if target == baseDir {
-			continue
+// renameGithubEntry implements a GitHub tarball's layout: every entry is
+// rooted under a single "<user>-<repo>-<hash>/" directory, and only the
+// project's selected Packages subdirectories are kept.
+func (pj *project) renameGithubEntry(name string) (string, bool) {
+	nameDirs := strings.Split(name, "/")
+	if len(nameDirs) > 2 {
+		pkg := filepath.Join(nameDirs[1 : len(nameDirs)-1]...)
+		if !pj.subdirTable[pkg] {
+			return "", false
 		}
+	}
+	return filepath.Join(nameDirs[1:]...), true
+}
 
-		if *fVerbose {
-			fmt.Println("Writing:", target)
-		}
+// fetchGithubTarball downloads the tarball for user/repo at rev into a
+// temp file and returns its path.
+func fetchGithubTarball(ctx context.Context, user, repo, rev string) (string, error) {
+	tarballUrl := fmt.Sprintf("https://api.github.com/repos/%s/%s/tarball/%s", user, repo, rev)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tarballUrl, nil)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	resp, err := doGithubRequest(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
 
-		switch hdr.Typeflag {
-		case tar.TypeReg, tar.TypeRegA:
-			f, err := os.OpenFile(target, os.O_RDWR|os.O_CREATE, os.FileMode(hdr.Mode))
-			if err != nil {
-				return errors.WithStack(err)
-			}
-			defer f.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("failed getting tarball: status %s (URL: %s)", resp.Status, tarballUrl)
+	}
 
-			if _, err := io.Copy(f, files); err != nil {
-				return errors.WithStack(err)
-			}
-			f.Close()
-		case tar.TypeDir:
-			if err := os.MkdirAll(target, 0777); err != nil && !os.IsExist(err) {
-				return errors.WithStack(err)
-			}
-		case tar.TypeSymlink:
-			if err := os.Symlink(target, hdr.Linkname); err != nil {
-				return errors.WithStack(err)
-			}
-		}
-		os.Chtimes(target, hdr.AccessTime, hdr.ModTime)
+	tmp, err := ioutil.TempFile("", "dep-dl-tar-*.tar.gz")
+	if err != nil {
+		return "", errors.WithStack(err)
 	}
+	defer tmp.Close()
 
-	return nil
+	if _, err := io.Copy(tmp, resp.Body); err != nil {
+		return "", errors.WithStack(err)
+	}
+	return tmp.Name(), nil
 }
 
-func (pj *project) dlGit(path string) ([]byte, error) {
-	if match := githubRegexp.FindStringSubmatch(path); match != nil {
-		return nil, pj.dlGithub(match[1], match[2])
+// dlVCS checks out path at pj.Revision into vendor/<pj.Name> using backend.
+// As a fast path, a git backend pointed at a GitHub repo instead downloads
+// a tarball through the GitHub API rather than shelling out to git.
+func (pj *project) dlVCS(ctx context.Context, backend VCSBackend, path string) ([]byte, error) {
+	if backend.Name() == "git" {
+		if match := githubRegexp.FindStringSubmatch(path); match != nil {
+			return nil, pj.dlGithub(ctx, match[1], match[2])
+		}
 	}
 
 	baseDir := filepath.Join(vendorDir, pj.Name)
@@ -145,17 +154,19 @@ func (pj *project) dlGit(path string) ([]byte, error) {
 
 	os.RemoveAll(baseDir)
 
-	cloneCmd := exec.Command("git", "clone", path, baseDir)
-	if buf, err := cloneCmd.Output(); err != nil {
-		return buf, errors.WithStack(err)
+	if cacheDir, cached := cacheLookupDir(pj.Name, pj.Revision); cached {
+		if *fVerbose {
+			fmt.Println("Using cached checkout:", pj.Name, pj.Revision)
+		}
+		return nil, copyDir(cacheDir, baseDir)
 	}
 
-	resetCmd := exec.Command("git", "reset", "--hard", pj.Revision)
-	resetCmd.Dir = baseDir
-	if buf, err := resetCmd.Output(); err != nil {
-		return buf, errors.WithStack(err)
+	if err := backend.Clone(ctx, baseDir, path, pj.Revision); err != nil {
+		return nil, err
+	}
+	if _, err := cacheStoreDir(pj.Name, pj.Revision, baseDir); err != nil {
+		return nil, err
 	}
-
 	return nil, nil
 }
 
@@ -245,10 +256,14 @@ func attrValue(attrs []xml.Attr, name string) string {
 	return ""
 }
 
-func getGoImports(path string) (*metaImport, error) {
-	resp, err := http.Get(fmt.Sprintf("https://%s?go-get=1", path))
+func getGoImports(ctx context.Context, path string) (*metaImport, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("https://%s?go-get=1", path), nil)
 	if err != nil {
-		return nil, err
+		return nil, errors.WithStack(err)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, errors.WithStack(err)
 	}
 	defer resp.Body.Close()
 
@@ -263,9 +278,7 @@ func getGoImports(path string) (*metaImport, error) {
 	return &imports[0], nil
 }
 
-func (pj *project) download(swg *sizedwaitgroup.SizedWaitGroup) {
-	defer swg.Done()
-
+func (pj *project) download(ctx context.Context) error {
 	pj.subdirTable = make(map[string]bool, len(pj.Packages))
 	for _, dir := range pj.Packages {
 		if dir == "." {
@@ -274,6 +287,10 @@ func (pj *project) download(swg *sizedwaitgroup.SizedWaitGroup) {
 		pj.subdirTable[dir] = true
 	}
 
+	if handled, err := pj.tryModuleProxy(ctx); handled {
+		return err
+	}
+
 	src := pj.Source
 	if len(src) == 0 {
 		src = pj.Name
@@ -281,10 +298,7 @@ func (pj *project) download(swg *sizedwaitgroup.SizedWaitGroup) {
 
 	if match := githubRegexp.FindStringSubmatch(src); match != nil {
 		fmt.Println("Downloading from github:", pj.Name, "(", src, pj.Revision, ")")
-		if err := pj.dlGithub(match[1], match[2]); err != nil {
-			panic(err)
-		}
-		return
+		return pj.dlGithub(ctx, match[1], match[2])
 	}
 
 	if match := gopkgRegexp.FindStringSubmatch(src); match != nil {
@@ -293,34 +307,32 @@ func (pj *project) download(swg *sizedwaitgroup.SizedWaitGroup) {
 		if !strings.HasPrefix(src, "https://") {
 			gitUrl = "https://" + src
 		}
-		if log, err := pj.dlGit(gitUrl); err != nil {
-			if log != nil {
-				fmt.Fprintln(os.Stderr, string(log))
-			}
-			fmt.Fprintln(os.Stderr, pj.Name)
-			fmt.Fprintf(os.Stderr, "%+v\n", err)
-			panic(err)
+		backend, err := vcsBackend("git")
+		if err != nil {
+			return err
 		}
-		return
+		log, err := pj.dlVCS(ctx, backend, gitUrl)
+		if err != nil && log != nil {
+			fmt.Fprintln(os.Stderr, string(log))
+		}
+		return err
 	}
 
-	meta, err := getGoImports(src)
+	meta, err := getGoImports(ctx, src)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	if strings.ToLower(meta.VCS) != "git" {
-		panic(fmt.Errorf("Unsupported VCS type: %s", meta.VCS))
+	backend, err := vcsBackend(meta.VCS)
+	if err != nil {
+		return err
 	}
 
 	fmt.Println("Downloading from go-imports:", pj.Name, "(", meta.RepoRoot, pj.Revision, ")")
-	if log, err := pj.dlGit(meta.RepoRoot); err != nil {
-		if log != nil {
-			fmt.Fprintln(os.Stderr, string(log))
-		}
-		fmt.Fprintln(os.Stderr, pj.Name)
-		fmt.Fprintf(os.Stderr, "%+v\n", err)
-		panic(err)
+	log, err := pj.dlVCS(ctx, backend, meta.RepoRoot)
+	if err != nil && log != nil {
+		fmt.Fprintln(os.Stderr, string(log))
 	}
+	return err
 }
 
 func main() {
@@ -346,27 +358,90 @@ func main() {
 
 	vendorDir = filepath.Join(wd, "vendor")
 
-	// read Gopkg.lock
-	lockfile, err := os.Open(filepath.Join(wd, "Gopkg.lock"))
-	if err != nil {
+	var projects []*project
+	if lockfile, err := os.Open(filepath.Join(wd, "Gopkg.lock")); err == nil {
+		var lock lock
+		err := toml.NewDecoder(lockfile).Decode(&lock)
+		lockfile.Close()
+		if err != nil {
+			panic(err)
+		}
+		projects = lock.Projects
+	} else if !os.IsNotExist(err) {
 		panic(err)
 	}
 
-	var lock lock
-	if err := toml.NewDecoder(lockfile).Decode(&lock); err != nil {
+	goMod, goSum, err := readGoModules(wd)
+	if err != nil {
 		panic(err)
 	}
 
+	if len(projects) == 0 && goMod == nil {
+		panic("no Gopkg.lock or go.mod found in " + wd)
+	}
+
 	fmt.Println("Download start:")
 
-	swg := sizedwaitgroup.New(*fParallelism)
-	for _, pj := range lock.Projects {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var failed int32
+	results := make(chan projectResult, len(projects)+len(goModRequires(goMod)))
+
+	dispatch := func(swg *sizedwaitgroup.SizedWaitGroup, name string, run func(ctx context.Context) error) {
 		swg.Add()
-		go func(pj *project, swg *sizedwaitgroup.SizedWaitGroup) {
-			pj.download(swg)
-		}(pj, &swg)
+		go func() {
+			defer swg.Done()
+			err := run(ctx)
+			if err != nil {
+				atomic.StoreInt32(&failed, 1)
+				if *fFailFast {
+					cancel()
+				}
+			}
+			results <- projectResult{Name: name, Err: err}
+		}()
+	}
+
+	swg := sizedwaitgroup.New(*fParallelism)
+	for _, pj := range projects {
+		if !*fKeepGoing && atomic.LoadInt32(&failed) == 1 {
+			break
+		}
+		pj := pj
+		dispatch(&swg, pj.Name, pj.download)
+	}
+	for _, req := range goModRequires(goMod) {
+		if !*fKeepGoing && atomic.LoadInt32(&failed) == 1 {
+			break
+		}
+		mp := &moduleProject{Path: req.Path, Version: req.Version}
+		dispatch(&swg, mp.Path, func(ctx context.Context) error { return mp.download(ctx, goMod, goSum) })
 	}
 	swg.Wait()
+	close(results)
+
+	var succeeded, failedCount int
+	for r := range results {
+		if r.Err == nil {
+			succeeded++
+			continue
+		}
+		failedCount++
+		fmt.Fprintf(os.Stderr, "FAILED %s: %+v\n", r.Name, r.Err)
+	}
+
+	fmt.Printf("Download done: %d succeeded, %d failed.\n", succeeded, failedCount)
+	if failedCount > 0 {
+		os.Exit(1)
+	}
+}
 
-	fmt.Println("Download done.")
+// goModRequires returns mod's requirements, or nil if mod is nil (no
+// go.mod was found).
+func goModRequires(mod *goModFile) []moduleRequire {
+	if mod == nil {
+		return nil
+	}
+	return mod.Require
 }
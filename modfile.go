@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// moduleRequire is a single module path/version pair, as found in a go.mod
+// require directive or on either side of a replace directive.
+type moduleRequire struct {
+	Path    string
+	Version string
+}
+
+// moduleReplace is a parsed go.mod replace directive.
+type moduleReplace struct {
+	Old moduleRequire
+	New moduleRequire
+}
+
+// goModFile is the subset of go.mod this tool needs to drive downloads:
+// the module's own path plus its requirements and replacements.
+type goModFile struct {
+	Module  string
+	Require []moduleRequire
+	Replace []moduleReplace
+}
+
+// parseGoMod parses a go.mod file well enough to drive downloads: module,
+// require and replace directives, in both single-line and parenthesized
+// block form. It is not a full go.mod parser (no build list pruning,
+// exclude/retract semantics, or go.work support).
+func parseGoMod(r io.Reader) (*goModFile, error) {
+	mod := &goModFile{}
+	scanner := bufio.NewScanner(r)
+
+	var block string
+	for scanner.Scan() {
+		line := strings.TrimSpace(stripGoModComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+
+		if block != "" {
+			if line == ")" {
+				block = ""
+				continue
+			}
+			if err := mod.parseDirective(block, line); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		fields := strings.Fields(line)
+		switch fields[0] {
+		case "module":
+			if len(fields) < 2 {
+				return nil, errors.Errorf("malformed module directive: %q", line)
+			}
+			mod.Module = fields[1]
+		case "require", "replace", "exclude", "retract":
+			rest := strings.TrimSpace(line[len(fields[0]):])
+			if rest == "(" {
+				block = fields[0]
+				continue
+			}
+			if err := mod.parseDirective(fields[0], rest); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return mod, nil
+}
+
+func stripGoModComment(line string) string {
+	if i := strings.Index(line, "//"); i >= 0 {
+		return line[:i]
+	}
+	return line
+}
+
+func (mod *goModFile) parseDirective(kind, rest string) error {
+	switch kind {
+	case "require":
+		fields := strings.Fields(rest)
+		if len(fields) < 2 {
+			return errors.Errorf("malformed require directive: %q", rest)
+		}
+		mod.Require = append(mod.Require, moduleRequire{Path: fields[0], Version: fields[1]})
+	case "replace":
+		arrow := strings.Index(rest, "=>")
+		if arrow < 0 {
+			return errors.Errorf("malformed replace directive: %q", rest)
+		}
+		oldFields := strings.Fields(rest[:arrow])
+		newFields := strings.Fields(rest[arrow+2:])
+		if len(oldFields) == 0 || len(newFields) == 0 {
+			return errors.Errorf("malformed replace directive: %q", rest)
+		}
+		r := moduleReplace{Old: moduleRequire{Path: oldFields[0]}, New: moduleRequire{Path: newFields[0]}}
+		if len(oldFields) > 1 {
+			r.Old.Version = oldFields[1]
+		}
+		if len(newFields) > 1 {
+			r.New.Version = newFields[1]
+		}
+		mod.Replace = append(mod.Replace, r)
+	}
+	// exclude/retract directives are recognized but not acted on: this
+	// tool downloads the require list as-is rather than building a module
+	// graph.
+	return nil
+}
+
+// resolve applies any matching replace directive to path/version, mirroring
+// `go mod`'s replace precedence: a version-specific replace wins over a
+// blanket (version-less) one for the same path.
+func (mod *goModFile) resolve(path, version string) (string, string) {
+	var blanket *moduleReplace
+	for i, r := range mod.Replace {
+		if r.Old.Path != path {
+			continue
+		}
+		if r.Old.Version == version {
+			return r.New.Path, r.New.Version
+		}
+		if r.Old.Version == "" {
+			blanket = &mod.Replace[i]
+		}
+	}
+	if blanket != nil {
+		return blanket.New.Path, blanket.New.Version
+	}
+	return path, version
+}
+
+// parseGoSum parses a go.sum file into a "path@version" to h1 hash lookup
+// table. Both the module zip line and the "/go.mod" line share this table;
+// callers look up by the exact key they need.
+func parseGoSum(r io.Reader) (map[string]string, error) {
+	sums := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, errors.Errorf("malformed go.sum line: %q", line)
+		}
+		sums[fields[0]+"@"+fields[1]] = fields[2]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return sums, nil
+}
+
+// readGoModules reads go.mod (and go.sum, if present) from dir. It returns
+// a nil goModFile, not an error, when dir has no go.mod: modules support is
+// additive to the existing Gopkg.lock flow.
+func readGoModules(dir string) (*goModFile, map[string]string, error) {
+	modFile, err := os.Open(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, errors.WithStack(err)
+	}
+	defer modFile.Close()
+
+	mod, err := parseGoMod(modFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sumFile, err := os.Open(filepath.Join(dir, "go.sum"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return mod, nil, nil
+		}
+		return nil, nil, errors.WithStack(err)
+	}
+	defer sumFile.Close()
+
+	sums, err := parseGoSum(sumFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	return mod, sums, nil
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// moduleProject mirrors project, but is driven by go.mod/go.sum (Go
+// modules) rather than Gopkg.lock: instead of cloning a VCS it fetches a
+// module zip from the Go module proxy.
+type moduleProject struct {
+	Path    string
+	Version string
+}
+
+const defaultGoproxy = "https://proxy.golang.org,direct"
+
+// goproxyGroup is one comma-separated element of GOPROXY, itself made up
+// of pipe-separated entries. Per the cmd/go spec: the tool falls back to
+// the next group on any error, but only falls back within a group (pipe
+// entries) on a "not found" (404/410) response.
+type goproxyGroup struct {
+	entries []string
+}
+
+// goproxyGroups parses GOPROXY into its comma/pipe-separated fallback
+// groups, defaulting to proxy.golang.org when unset.
+func goproxyGroups() []goproxyGroup {
+	gp := os.Getenv("GOPROXY")
+	if gp == "" {
+		gp = defaultGoproxy
+	}
+	groups := make([]goproxyGroup, 0)
+	for _, g := range strings.Split(gp, ",") {
+		groups = append(groups, goproxyGroup{entries: strings.Split(g, "|")})
+	}
+	return groups
+}
+
+// proxyNotFoundError marks a proxy response as an authoritative "this
+// module isn't here" (HTTP 404/410), as distinct from a transient or
+// network error, so pipe-separated GOPROXY entries can tell whether to
+// keep trying within the same group.
+type proxyNotFoundError struct {
+	status string
+}
+
+func (e *proxyNotFoundError) Error() string { return "module not found on proxy: " + e.status }
+
+func isProxyNotFound(err error) bool {
+	type causer interface{ Cause() error }
+	for err != nil {
+		if _, ok := err.(*proxyNotFoundError); ok {
+			return true
+		}
+		c, ok := err.(causer)
+		if !ok {
+			return false
+		}
+		err = c.Cause()
+	}
+	return false
+}
+
+// escapeModulePath escapes a module path or version component the way the
+// Go module proxy protocol requires: every uppercase letter is replaced by
+// an exclamation mark followed by its lowercase equivalent, since proxies
+// are commonly backed by case-insensitive file systems.
+func escapeModulePath(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r >= 'A' && r <= 'Z' {
+			b.WriteByte('!')
+			b.WriteRune(r - 'A' + 'a')
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// download fetches the module's zip from the proxies listed in GOPROXY, in
+// order, falling back to a direct VCS checkout for a "direct" entry and
+// refusing outright on "off".
+func (mp *moduleProject) download(ctx context.Context, mod *goModFile, sums map[string]string) error {
+	path, version := mp.Path, mp.Version
+	if mod != nil {
+		path, version = mod.resolve(path, version)
+	}
+
+	var lastErr error
+	for _, group := range goproxyGroups() {
+		for _, proxy := range group.entries {
+			switch proxy {
+			case "off":
+				return errors.Errorf("module download disabled (GOPROXY=off): %s@%s", path, version)
+			case "direct":
+				return mp.downloadDirect(ctx, path, version)
+			}
+
+			err := mp.downloadFromProxy(ctx, proxy, path, version, sums)
+			if err == nil {
+				return nil
+			}
+			fmt.Fprintf(os.Stderr, "proxy %s failed for %s@%s: %+v\n", proxy, path, version, err)
+			lastErr = err
+			if !isProxyNotFound(err) {
+				break // move on to the next comma-separated group
+			}
+		}
+	}
+	return errors.Wrapf(lastErr, "all GOPROXY entries failed for %s@%s", path, version)
+}
+
+// downloadFromProxy fetches (or reuses a cached copy of) and verifies a
+// module zip from proxy, then extracts it into vendor/<path>.
+func (mp *moduleProject) downloadFromProxy(ctx context.Context, proxy, path, version string, sums map[string]string) error {
+	zipPath, cached := cacheLookup(path, version, ".zip")
+	if !cached {
+		modBytes, err := fetchModuleMod(ctx, proxy, path, version)
+		if err != nil {
+			return err
+		}
+
+		tmp, err := fetchModuleZip(ctx, proxy, path, version)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp)
+
+		if err := verifyModuleHashes(ctx, tmp, modBytes, path, version, sums); err != nil {
+			return err
+		}
+
+		if zipPath, err = cacheStore(path, version, ".zip", tmp); err != nil {
+			return err
+		}
+	} else if *fVerbose {
+		fmt.Println("Using cached module zip:", path, version)
+	}
+
+	if *fVerbose {
+		fmt.Println("Downloading from module proxy:", path, "(", proxy, version, ")")
+	}
+
+	baseDir := filepath.Join(vendorDir, path)
+	if err := os.RemoveAll(baseDir); err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+	if err := os.MkdirAll(baseDir, 0777); err != nil {
+		return errors.WithStack(err)
+	}
+
+	zr, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer zr.Close()
+	return extractModuleZip(&zr.Reader, path, version, baseDir)
+}
+
+// fetchModuleZip downloads path@version's zip from proxy into a temp file
+// and returns its path.
+func fetchModuleZip(ctx context.Context, proxy, path, version string) (string, error) {
+	zipURL := fmt.Sprintf("%s/%s/@v/%s.zip", strings.TrimRight(proxy, "/"), escapeModulePath(path), escapeModulePath(version))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, zipURL, nil)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		return "", &proxyNotFoundError{status: resp.Status}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("failed getting module zip: status %s (URL: %s)", resp.Status, zipURL)
+	}
+
+	tmp, err := ioutil.TempFile("", "dep-dl-mod-*.zip")
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer tmp.Close()
+
+	if _, err := io.Copy(tmp, resp.Body); err != nil {
+		return "", errors.WithStack(err)
+	}
+	return tmp.Name(), nil
+}
+
+// fetchModuleMod downloads path@version's go.mod from proxy and returns its
+// raw bytes.
+func fetchModuleMod(ctx context.Context, proxy, path, version string) ([]byte, error) {
+	modURL := fmt.Sprintf("%s/%s/@v/%s.mod", strings.TrimRight(proxy, "/"), escapeModulePath(path), escapeModulePath(version))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, modURL, nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		return nil, &proxyNotFoundError{status: resp.Status}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("failed getting go.mod: status %s (URL: %s)", resp.Status, modURL)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return data, nil
+}
+
+// verifyModuleHashes checks zipPath's and modBytes' h1 hashes against
+// go.sum, which carries both the module zip hash (under "path@version") and
+// its go.mod hash (under "path@version/go.mod") independently. Either that
+// is missing from go.sum is looked up from GOSUMDB instead; a failed
+// GOSUMDB lookup is a hard error (fail closed), since skipping verification
+// silently would defeat the point of checking checksums at all.
+func verifyModuleHashes(ctx context.Context, zipPath string, modBytes []byte, path, version string, sums map[string]string) error {
+	wantZip, zipOK := sums[path+"@"+version]
+	wantMod, modOK := sums[path+"@"+version+"/go.mod"]
+
+	if !zipOK || !modOK {
+		if os.Getenv("GOSUMDB") == "off" {
+			return errors.Errorf("no go.sum entry for %s@%s and GOSUMDB is disabled", path, version)
+		}
+		dbZip, dbMod, err := lookupGosumdb(ctx, path, version)
+		if err != nil {
+			return errors.Wrapf(err, "no go.sum entry for %s@%s and GOSUMDB lookup failed", path, version)
+		}
+		if !zipOK {
+			wantZip = dbZip
+		}
+		if !modOK {
+			wantMod = dbMod
+		}
+	}
+
+	if got, err := hashZip(zipPath); err != nil {
+		return err
+	} else if got != wantZip {
+		return errors.Errorf("checksum mismatch for %s@%s: have %s, want %s", path, version, got, wantZip)
+	}
+
+	if got := hashGoMod(path, version, modBytes); got != wantMod {
+		return errors.Errorf("go.mod checksum mismatch for %s@%s: have %s, want %s", path, version, got, wantMod)
+	}
+	return nil
+}
+
+// extractModuleZip extracts a module zip (as returned by a Go module
+// proxy) into baseDir, stripping the "<path>@<version>/" prefix every
+// entry carries.
+func extractModuleZip(zr *zip.Reader, path, version, baseDir string) error {
+	prefix := path + "@" + version + "/"
+	return safeExtractZip(zr, baseDir, func(name string) (string, bool) {
+		if !strings.HasPrefix(name, prefix) {
+			return "", false
+		}
+		return strings.TrimPrefix(name, prefix), true
+	})
+}
+
+// downloadDirect checks the module out directly from its origin VCS,
+// bypassing the module proxy entirely (GOPROXY=direct). It dispatches
+// through the same vcsBackend registry project.download uses, so non-git
+// go-import modules (hg, svn, bzr, fossil) work here too.
+func (mp *moduleProject) downloadDirect(ctx context.Context, path, version string) error {
+	backend := vcsBackends["git"]
+	repoURL := path
+	if match := githubRegexp.FindStringSubmatch(path); match != nil {
+		repoURL = fmt.Sprintf("https://github.com/%s/%s", match[1], match[2])
+	} else if !strings.Contains(path, "://") {
+		meta, err := getGoImports(ctx, path)
+		if err != nil {
+			return err
+		}
+		b, err := vcsBackend(meta.VCS)
+		if err != nil {
+			return err
+		}
+		backend, repoURL = b, meta.RepoRoot
+	}
+	if _, err := vcsBackend(backend.Name()); err != nil {
+		return err
+	}
+
+	baseDir := filepath.Join(vendorDir, path)
+	if err := os.MkdirAll(filepath.Dir(baseDir), 0777); err != nil && !os.IsExist(err) {
+		return errors.WithStack(err)
+	}
+	os.RemoveAll(baseDir)
+
+	return backend.Clone(ctx, baseDir, repoURL, pseudoVersionRevision(version))
+}
+
+// pseudoVersionRevision extracts the commit hash from a Go pseudo-version
+// (e.g. "v0.0.0-20200101000000-abcdef123456" -> "abcdef123456"), or
+// returns version unchanged if it isn't one.
+func pseudoVersionRevision(version string) string {
+	idx := strings.LastIndex(version, "-")
+	if idx < 0 {
+		return version
+	}
+	rev := version[idx+1:]
+	if len(rev) != 12 {
+		return version
+	}
+	return rev
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// pseudoVersion synthesizes a Go pseudo-version for a project pinned to a
+// revision but with no explicit module Version, in the
+// v0.0.0-<yyyymmddhhmmss>-<12char commit> form cmd/go uses for commits
+// with no matching tag.
+func (pj *project) pseudoVersion(ctx context.Context) (string, error) {
+	if len(pj.Revision) < 12 {
+		return "", errors.Errorf("revision %q too short to derive a pseudo-version", pj.Revision)
+	}
+
+	t, err := pj.commitTime(ctx)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("v0.0.0-%s-%s", t.UTC().Format("20060102150405"), pj.Revision[:12]), nil
+}
+
+// commitTime looks up the commit time for pj.Revision, needed to build a
+// pseudo-version. Only GitHub sources are supported.
+func (pj *project) commitTime(ctx context.Context) (time.Time, error) {
+	src := pj.Source
+	if len(src) == 0 {
+		src = pj.Name
+	}
+	match := githubRegexp.FindStringSubmatch(src)
+	if match == nil {
+		return time.Time{}, errors.Errorf("commit time lookup unsupported for source: %s", src)
+	}
+
+	apiURL := fmt.Sprintf("https://api.github.com/repos/%s/%s/commits/%s", match[1], match[2], pj.Revision)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
+	if err != nil {
+		return time.Time{}, errors.WithStack(err)
+	}
+	resp, err := doGithubRequest(ctx, req)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return time.Time{}, errors.Errorf("failed getting commit info: status %s (URL: %s)", resp.Status, apiURL)
+	}
+
+	var commit struct {
+		Commit struct {
+			Committer struct {
+				Date time.Time `json:"date"`
+			} `json:"committer"`
+		} `json:"commit"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&commit); err != nil {
+		return time.Time{}, errors.WithStack(err)
+	}
+	return commit.Commit.Committer.Date, nil
+}
+
+// sourceMatchesName reports whether pj's Source (when set) points at the
+// same repository as its canonical Name. Gopkg.lock commonly pins a fork
+// via Source while Name stays the canonical import path; an empty Source
+// trivially matches.
+func (pj *project) sourceMatchesName() bool {
+	if pj.Source == "" {
+		return true
+	}
+	src := strings.TrimPrefix(strings.TrimPrefix(pj.Source, "https://"), "http://")
+	return src == pj.Name
+}
+
+// tryModuleProxy attempts to satisfy the project from the Go module proxy
+// before falling back to the GitHub tarball API or a raw VCS checkout. It
+// reports handled=false (not an error) whenever the proxy path isn't
+// applicable or doesn't have the module, so download can fall back to its
+// existing behavior.
+func (pj *project) tryModuleProxy(ctx context.Context) (handled bool, err error) {
+	if os.Getenv("GOPROXY") == "off" {
+		return false, nil
+	}
+	if !pj.sourceMatchesName() {
+		// The module proxy protocol is keyed on the canonical import path
+		// alone; it has no way to serve the pinned fork in Source. Asking
+		// it for pj.Name here would silently vendor the wrong repo.
+		return false, nil
+	}
+
+	version := pj.Version
+	if version == "" {
+		pv, err := pj.pseudoVersion(ctx)
+		if err != nil {
+			if *fVerbose {
+				fmt.Fprintf(os.Stderr, "skipping module proxy for %s: %+v\n", pj.Name, err)
+			}
+			return false, nil
+		}
+		version = pv
+	}
+
+	mp := &moduleProject{Path: pj.Name, Version: version}
+	if err := mp.download(ctx, nil, nil); err != nil {
+		if *fVerbose {
+			fmt.Fprintf(os.Stderr, "module proxy fetch failed for %s@%s, falling back: %+v\n", pj.Name, version, err)
+		}
+		return false, nil
+	}
+	return true, nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// VCSBackend abstracts a version control tool capable of checking out a
+// repository at a specific revision, mirroring the set cmd/go's discovery
+// historically supported (git, hg, svn, bzr, fossil).
+type VCSBackend interface {
+	// Name is the VCS identifier as used in go-import meta tags (e.g.
+	// "git", "hg").
+	Name() string
+	// Clone checks out repo at rev into dst, which does not yet exist.
+	Clone(ctx context.Context, dst, repo, rev string) error
+}
+
+type gitBackend struct{}
+
+func (gitBackend) Name() string { return "git" }
+
+func (gitBackend) Clone(ctx context.Context, dst, repo, rev string) error {
+	cloneCmd := exec.CommandContext(ctx, "git", "clone", repo, dst)
+	if buf, err := cloneCmd.CombinedOutput(); err != nil {
+		return errors.Wrap(err, string(buf))
+	}
+	resetCmd := exec.CommandContext(ctx, "git", "reset", "--hard", rev)
+	resetCmd.Dir = dst
+	if buf, err := resetCmd.CombinedOutput(); err != nil {
+		return errors.Wrap(err, string(buf))
+	}
+	return nil
+}
+
+type hgBackend struct{}
+
+func (hgBackend) Name() string { return "hg" }
+
+func (hgBackend) Clone(ctx context.Context, dst, repo, rev string) error {
+	cloneCmd := exec.CommandContext(ctx, "hg", "clone", repo, dst)
+	if buf, err := cloneCmd.CombinedOutput(); err != nil {
+		return errors.Wrap(err, string(buf))
+	}
+	updateCmd := exec.CommandContext(ctx, "hg", "update", "-r", rev)
+	updateCmd.Dir = dst
+	if buf, err := updateCmd.CombinedOutput(); err != nil {
+		return errors.Wrap(err, string(buf))
+	}
+	return nil
+}
+
+type svnBackend struct{}
+
+func (svnBackend) Name() string { return "svn" }
+
+func (svnBackend) Clone(ctx context.Context, dst, repo, rev string) error {
+	args := []string{"checkout", repo, dst}
+	if rev != "" {
+		args = []string{"checkout", "-r", rev, repo, dst}
+	}
+	cmd := exec.CommandContext(ctx, "svn", args...)
+	if buf, err := cmd.CombinedOutput(); err != nil {
+		return errors.Wrap(err, string(buf))
+	}
+	return nil
+}
+
+type bzrBackend struct{}
+
+func (bzrBackend) Name() string { return "bzr" }
+
+func (bzrBackend) Clone(ctx context.Context, dst, repo, rev string) error {
+	args := []string{"branch", repo, dst}
+	if rev != "" {
+		args = []string{"branch", "-r", "revid:" + rev, repo, dst}
+	}
+	cmd := exec.CommandContext(ctx, "bzr", args...)
+	if buf, err := cmd.CombinedOutput(); err != nil {
+		return errors.Wrap(err, string(buf))
+	}
+	return nil
+}
+
+type fossilBackend struct{}
+
+func (fossilBackend) Name() string { return "fossil" }
+
+func (fossilBackend) Clone(ctx context.Context, dst, repo, rev string) error {
+	repoFile := dst + ".fossil"
+	cloneCmd := exec.CommandContext(ctx, "fossil", "clone", repo, repoFile)
+	if buf, err := cloneCmd.CombinedOutput(); err != nil {
+		return errors.Wrap(err, string(buf))
+	}
+
+	if err := os.MkdirAll(dst, 0777); err != nil {
+		return errors.WithStack(err)
+	}
+	openCmd := exec.CommandContext(ctx, "fossil", "open", repoFile)
+	openCmd.Dir = dst
+	if buf, err := openCmd.CombinedOutput(); err != nil {
+		return errors.Wrap(err, string(buf))
+	}
+
+	if rev == "" {
+		return nil
+	}
+	updateCmd := exec.CommandContext(ctx, "fossil", "update", rev)
+	updateCmd.Dir = dst
+	if buf, err := updateCmd.CombinedOutput(); err != nil {
+		return errors.Wrap(err, string(buf))
+	}
+	return nil
+}
+
+var vcsBackends = map[string]VCSBackend{
+	"git":    gitBackend{},
+	"hg":     hgBackend{},
+	"svn":    svnBackend{},
+	"bzr":    bzrBackend{},
+	"fossil": fossilBackend{},
+}
+
+var fDisabledVCS = flag.String("vcs", "", "comma-separated list of VCS backends to disable (e.g. svn,bzr)")
+
+func disabledVCSSet() map[string]bool {
+	disabled := make(map[string]bool)
+	for _, name := range strings.Split(*fDisabledVCS, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			disabled[name] = true
+		}
+	}
+	return disabled
+}
+
+// vcsBackend looks up the backend registered for name (case-insensitive),
+// honoring any backends disabled via -vcs.
+func vcsBackend(name string) (VCSBackend, error) {
+	name = strings.ToLower(name)
+	if disabledVCSSet()[name] {
+		return nil, errors.Errorf("VCS backend %q is disabled by -vcs", name)
+	}
+	backend, ok := vcsBackends[name]
+	if !ok {
+		return nil, errors.Errorf("unsupported VCS type: %s", name)
+	}
+	return backend, nil
+}